@@ -1,47 +1,56 @@
 package ephemera
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"sync"
 	"time"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
 	"github.com/gorilla/mux"
-	"github.com/samalba/dockerclient"
 	"github.com/satori/go.uuid"
 )
 
 var (
 	containerPrefix = "ephemera"
-	dockerDebug     = false
 )
 
-// Callback used to listen to Docker's events
-func eventCallback(event *dockerclient.Event, ec chan error, args ...interface{}) {
-	log.Printf("Received event: %#v\n", *event)
+// TLSConfig holds the client certificate material needed to talk to a
+// TLS-secured Docker daemon, mirroring the files the `docker` CLI expects
+// under DOCKER_CERT_PATH (ca.pem, cert.pem, key.pem).
+type TLSConfig struct {
+	CACertPath string
+	CertPath   string
+	KeyPath    string
 }
 
 // Container represents a ephemeral container.
 type Container struct {
-	Name      string
-	ID        string
-	Image     string
-	IP        string
-	Proxy     http.Handler
-	Started   bool
-	StartedAt time.Time
-	TTL       time.Duration
-	Config    *dockerclient.ContainerConfig
-	e         *Ephemera
+	Name       string
+	ID         string
+	Image      string
+	IP         string
+	Proxy      http.Handler
+	Started    bool
+	StartedAt  time.Time
+	TTL        time.Duration
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	e          *Ephemera
+	pool       *Pool
 }
 
 // WaitKill blocks till the TTL is elapsed and kill the container.
 func (c *Container) WaitKill() {
 	<-time.After(c.TTL)
-	c.Kill()
+	if err := c.Kill(context.Background()); err != nil {
+		log.Printf("failed to kill %v: %v", c, err)
+	}
 	return
 }
 
@@ -50,125 +59,288 @@ func (c *Container) String() string {
 	return fmt.Sprintf("<Container %v [img=%v,started=%v,ttl=%v]>", c.Name, c.Config.Image, c.Started, c.TTL)
 }
 
-// Start actually start the container
-func (c *Container) Start() {
+// Start actually start the container.
+func (c *Container) Start(ctx context.Context) error {
 	if c.Started {
-		return
+		return nil
 	}
-	containerId, err := c.e.docker.CreateContainer(c.Config, fmt.Sprintf("%v-%v", containerPrefix, c.Name))
+	resp, err := c.e.docker.ContainerCreate(ctx, c.Config, c.HostConfig, nil, nil, fmt.Sprintf("%v-%v", containerPrefix, c.Name))
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to create container: %v", err)
+	}
+	if err := c.e.docker.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container: %v", err)
 	}
-	// Start the container
-	hostConfig := &dockerclient.HostConfig{}
-	err = c.e.docker.StartContainer(containerId, hostConfig)
+	info, err := c.e.docker.ContainerInspect(ctx, resp.ID)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to inspect container: %v", err)
 	}
-	time.Sleep(250 * time.Millisecond)
-	info, _ := c.e.docker.InspectContainer(containerId)
 	c.IP = info.NetworkSettings.IPAddress
-	c.ID = containerId
+	if c.IP == "" {
+		if netInfo, ok := info.NetworkSettings.Networks[c.HostConfig.NetworkMode.NetworkName()]; ok {
+			c.IP = netInfo.IPAddress
+		}
+	}
+	c.ID = resp.ID
+	if err := c.e.probeFor(c.Image).wait(ctx, c.IP); err != nil {
+		return err
+	}
 	c.Started = true
 	c.StartedAt = time.Now()
+	return nil
 }
 
-// Kill stops and removes the container.
-func (c *Container) Kill() {
+// Kill stops and removes the container. The Docker round trips run without
+// holding Ephemera's lock, so one slow/stale container being torn down
+// doesn't stall unrelated requests that only need the lock to touch the
+// containers map.
+func (c *Container) Kill(ctx context.Context) error {
+	timeout := 5
+	if c.Config.StopTimeout != nil {
+		timeout = *c.Config.StopTimeout
+	}
+	if err := c.e.docker.ContainerStop(ctx, c.ID, container.StopOptions{Timeout: &timeout, Signal: c.Config.StopSignal}); err != nil {
+		return fmt.Errorf("failed to stop container: %v", err)
+	}
+	if err := c.e.docker.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+		return fmt.Errorf("failed to remove container: %v", err)
+	}
 	c.e.Lock()
-	defer c.e.Unlock()
-	c.e.docker.StopContainer(c.ID, 5)
-	c.e.docker.RemoveContainer(c.ID, true, true)
 	delete(c.e.containers, c.Name)
+	c.e.Unlock()
+	if c.pool != nil {
+		c.pool.remove(c)
+	}
+	return nil
 }
 
 type Ephemera struct {
 	sync.Mutex
-	ttl        time.Duration
-	image      string
-	containers map[string]*Container
-	docker     *dockerclient.DockerClient
-	handler    http.Handler
+	ttl           time.Duration
+	image         string
+	containers    map[string]*Container
+	docker        client.APIClient
+	handler       http.Handler
+	probes        map[string]*Probe
+	pool          *Pool
+	opts          []ContainerOption
+	eventHandlers []func(ContainerEvent)
+	routeMode     RouteMode
+	baseDomain    string
+}
+
+// SetContainerOptions sets the ContainerOptions applied to every container
+// NewContainer creates from then on, including those spawned by newHandler
+// or by a Pool, e.g. resource limits or network mode for untrusted demo
+// images. Containers created before the call keep their configuration.
+func (e *Ephemera) SetContainerOptions(opts ...ContainerOption) {
+	e.Lock()
+	defer e.Unlock()
+	e.opts = opts
+}
+
+// SetProbe registers the readiness probe used for containers started from
+// img. Containers of images without a registered probe fall back to
+// DefaultProbe.
+func (e *Ephemera) SetProbe(img string, p *Probe) {
+	e.Lock()
+	defer e.Unlock()
+	e.probes[img] = p
+}
+
+// probeFor returns the readiness probe registered for img, or DefaultProbe
+// if none was set.
+func (e *Ephemera) probeFor(img string) *Probe {
+	e.Lock()
+	defer e.Unlock()
+	if p, ok := e.probes[img]; ok {
+		return p
+	}
+	return DefaultProbe
 }
 
 // KillAll kills all the spawned containers still alive.
-func (e *Ephemera) KillAll() {
+func (e *Ephemera) KillAll(ctx context.Context) {
+	e.Lock()
+	containers := make([]*Container, 0, len(e.containers))
 	for _, c := range e.containers {
+		containers = append(containers, c)
+	}
+	e.Unlock()
+
+	for _, c := range containers {
 		log.Printf("kill %v", c)
-		c.Kill()
+		if err := c.Kill(ctx); err != nil {
+			log.Printf("failed to kill %v: %v", c, err)
+		}
 	}
 }
 
-// RegisterHandler registers /demo/new and /demo/{id} routes.
-func (e *Ephemera) RegisterHandler(r *mux.Router) {
-	r.HandleFunc("/demo/new", e.newHandler)
-	r.PathPrefix("/demo/{id}").Handler(http.HandlerFunc(e.proxyHandler))
+// RegisterHandler registers the /demo/new endpoint and the container proxy
+// routes for the given RouteMode. In Subdomain mode, SetBaseDomain must be
+// called first.
+func (e *Ephemera) RegisterHandler(r *mux.Router, mode RouteMode) {
+	e.routeMode = mode
+	switch mode {
+	case Subdomain:
+		// Scoped to the base domain itself, so a demo app serving its own
+		// "/demo/new" path on "{id}.baseDomain" is proxied through, not
+		// hijacked by ephemera's container-creation handler.
+		r.Host(e.baseDomain).Path("/demo/new").HandlerFunc(e.newHandler)
+		r.Host("{id}." + e.baseDomain).Handler(http.HandlerFunc(e.proxyHandler))
+	default:
+		r.HandleFunc("/demo/new", e.newHandler)
+		r.PathPrefix("/demo/{id}").Handler(http.HandlerFunc(e.proxyHandler))
+	}
 }
 
-// Spawn a new container with the given Docker image and TTL.
+// Spawn a new container with the given Docker image and TTL, applying the
+// ContainerOptions registered via SetContainerOptions followed by the
+// given ones (so a call-site opt overrides a same-field default).
 // The container will be killed only if WaitKill/Kill is called manually.
-func (e *Ephemera) NewContainer(img string, ttl time.Duration) *Container {
+func (e *Ephemera) NewContainer(img string, ttl time.Duration, opts ...ContainerOption) *Container {
+	e.Lock()
+	defaultOpts := e.opts
+	e.Unlock()
+
+	spec := &ContainerSpec{}
+	for _, opt := range defaultOpts {
+		opt(spec)
+	}
+	for _, opt := range opts {
+		opt(spec)
+	}
 	e.Lock()
 	defer e.Unlock()
-	container := &Container{
+	c := &Container{
 		e:       e,
 		Name:    uuid.NewV4().String(),
 		Image:   img,
 		TTL:     ttl,
 		Started: false,
-		Config: &dockerclient.ContainerConfig{
-			Image: img,
+		Config: &container.Config{
+			Image:       img,
+			Env:         spec.Env,
+			Labels:      spec.Labels,
+			StopSignal:  spec.StopSignal,
+			StopTimeout: spec.StopTimeout,
+		},
+		HostConfig: &container.HostConfig{
+			NetworkMode:    spec.NetworkMode,
+			PortBindings:   spec.PortBindings,
+			Binds:          spec.Binds,
+			ReadonlyRootfs: spec.ReadonlyRootfs,
+			Resources: container.Resources{
+				Memory:   spec.Memory,
+				NanoCPUs: spec.NanoCPUs,
+			},
 		},
 	}
-	e.containers[container.Name] = container
-	return container
+	e.containers[c.Name] = c
+	return c
 }
 
 func (e *Ephemera) proxyHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-	log.Println("/demo/%v requested", id)
-	if c, ok := e.containers[id]; ok {
-		c.Proxy.ServeHTTP(w, r)
+	id := mux.Vars(r)["id"]
+	e.Lock()
+	c, ok := e.containers[id]
+	e.Unlock()
+	if !ok {
+		log.Printf("unknown id %v", id)
+		http.NotFound(w, r)
 		return
 	}
-	log.Printf("unknown id %v", id)
+	c.Proxy.ServeHTTP(w, r)
 }
 
 func (e *Ephemera) newHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("New container request")
-	c := e.NewContainer(e.image, e.ttl)
-	c.Start()
+	var c *Container
+	if e.pool != nil {
+		var err error
+		c, err = e.pool.Get(r.Context())
+		if err != nil {
+			log.Printf("failed to get a container from the pool: %v", err)
+			http.Error(w, "no container available", http.StatusServiceUnavailable)
+			return
+		}
+	} else {
+		c = e.NewContainer(e.image, e.ttl)
+		if err := c.Start(r.Context()); err != nil {
+			log.Printf("failed to start container: %v", err)
+			status := http.StatusBadGateway
+			if _, ok := err.(*ErrProbeTimeout); ok {
+				status = http.StatusGatewayTimeout
+			}
+			if c.ID != "" {
+				if kerr := c.Kill(context.Background()); kerr != nil {
+					log.Printf("failed to clean up %v: %v", c, kerr)
+				}
+			}
+			http.Error(w, "failed to start container", status)
+			return
+		}
+	}
 	log.Printf("container started: %v", c)
 	go c.WaitKill()
-	u, _ := url.Parse(fmt.Sprintf("http://%v:8080", c.IP))
-	c.Proxy = http.StripPrefix("/demo/"+c.Name, httputil.NewSingleHostReverseProxy(u))
-	log.Printf("container proxy setup /demo/%v => %v", c.Name, c.IP)
+	u, _ := url.Parse(fmt.Sprintf("http://%v:%v", c.IP, e.probeFor(c.Image).Port))
+
+	var location string
+	if e.routeMode == Subdomain {
+		c.Proxy = newProxy(u, "")
+		location = fmt.Sprintf("http://%v.%v/", c.Name, e.baseDomain)
+	} else {
+		c.Proxy = newProxy(u, "/demo/"+c.Name)
+		location = "/demo/" + c.Name
+	}
+	log.Printf("container proxy setup %v => %v", location, c.IP)
 	if r.URL.Query().Get("redirect") != "0" {
-		http.Redirect(w, r, "/demo/"+c.Name, http.StatusTemporaryRedirect)
+		http.Redirect(w, r, location, http.StatusTemporaryRedirect)
 		return
 	}
 	w.Write([]byte(c.Name))
 	return
 }
 
-// New initializes a new Ephemera instance.
-func New(dockerURI, image string, ttl time.Duration) (*Ephemera, error) {
+// New initializes a new Ephemera instance, connecting to the Docker daemon
+// at dockerURI (e.g. "tcp://127.0.0.1:2376" or "unix:///var/run/docker.sock").
+// If dockerURI is empty, the local unix socket is used. tlsConfig may be nil
+// to talk to an unsecured daemon.
+func New(dockerURI string, tlsConfig *TLSConfig, image string, ttl time.Duration) (*Ephemera, error) {
 	if dockerURI == "" {
 		dockerURI = "unix:///var/run/docker.sock"
 	}
-	// Init the Docker client
-	docker, err := dockerclient.NewDockerClient(dockerURI, nil)
+	opts := []client.Opt{client.WithHost(dockerURI), client.WithAPIVersionNegotiation()}
+	if tlsConfig != nil {
+		opts = append(opts, client.WithTLSClientConfig(tlsConfig.CACertPath, tlsConfig.CertPath, tlsConfig.KeyPath))
+	}
+	docker, err := client.NewClientWithOpts(opts...)
 	if err != nil {
 		return nil, err
 	}
-	if dockerDebug {
-		docker.StartMonitorEvents(eventCallback, nil)
+	return &Ephemera{
+		containers: map[string]*Container{},
+		docker:     docker,
+		ttl:        ttl,
+		image:      image,
+		probes:     map[string]*Probe{},
+	}, nil
+}
+
+// NewFromEnv initializes a new Ephemera instance using the same environment
+// variables as the `docker` CLI: DOCKER_HOST, DOCKER_TLS_VERIFY,
+// DOCKER_CERT_PATH and DOCKER_API_VERSION. This is the easiest way to target
+// a remote or TLS-secured daemon (or a Swarm endpoint).
+func NewFromEnv(image string, ttl time.Duration) (*Ephemera, error) {
+	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
 	}
 	return &Ephemera{
 		containers: map[string]*Container{},
 		docker:     docker,
 		ttl:        ttl,
 		image:      image,
+		probes:     map[string]*Probe{},
 	}, nil
 }
@@ -0,0 +1,137 @@
+package ephemera
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// ContainerEvent is reported to handlers registered with Ephemera.OnEvent.
+type ContainerEvent struct {
+	// Action is the Docker event action, e.g. "die", "oom", "destroy" or
+	// "health_status: unhealthy".
+	Action string
+	// Container is the container the event concerns. It may be nil if
+	// the event arrived for a container ephemera didn't spawn, or after
+	// it was already removed from Ephemera.containers.
+	Container *Container
+	// ID is the raw Docker container ID the event was reported for.
+	ID string
+}
+
+// OnEvent registers a callback invoked for every container event received
+// by WatchEvents, after Ephemera.containers has been updated. Events for
+// containers ephemera doesn't manage are passed on too, with a nil
+// ContainerEvent.Container.
+// It may be called concurrently and multiple times; each call adds a
+// callback, it doesn't replace previously registered ones.
+func (e *Ephemera) OnEvent(cb func(ContainerEvent)) {
+	e.Lock()
+	defer e.Unlock()
+	e.eventHandlers = append(e.eventHandlers, cb)
+}
+
+func (e *Ephemera) emit(ev ContainerEvent) {
+	e.Lock()
+	handlers := append([]func(ContainerEvent){}, e.eventHandlers...)
+	e.Unlock()
+	for _, cb := range handlers {
+		cb(ev)
+	}
+}
+
+// WatchEvents subscribes to the Docker events stream and keeps
+// Ephemera.containers in sync: a container that dies, OOMs, is destroyed
+// or reports unhealthy is removed immediately, instead of waiting for its
+// WaitKill goroutine to try (and fail) to Stop/Remove it later. It
+// reconnects with exponential backoff if the stream drops, and blocks
+// until ctx is cancelled.
+func (e *Ephemera) WatchEvents(ctx context.Context) {
+	backoff := time.Second
+	const maxBackoff = 30 * time.Second
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := e.watchEventsOnce(ctx); err != nil {
+			log.Printf("events stream disconnected, reconnecting in %v: %v", backoff, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = time.Second
+	}
+}
+
+func (e *Ephemera) watchEventsOnce(ctx context.Context) error {
+	f := filters.NewArgs()
+	f.Add("type", "container")
+	f.Add("event", "die")
+	f.Add("event", "oom")
+	f.Add("event", "destroy")
+	f.Add("event", "health_status")
+	msgs, errs := e.docker.Events(ctx, types.EventsOptions{Filters: f})
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return io.EOF
+			}
+			e.handleEvent(msg.Action, msg.Actor.ID)
+		case err := <-errs:
+			if err == nil || err == io.EOF {
+				return io.EOF
+			}
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// isTerminal reports whether action means the container is gone or will
+// never become healthy again, as opposed to "health_status: healthy",
+// which Docker reports for perfectly fine, still-running containers.
+func isTerminal(action string) bool {
+	switch {
+	case action == "die", action == "oom", action == "destroy":
+		return true
+	case strings.HasPrefix(action, "health_status:") && strings.TrimSpace(strings.TrimPrefix(action, "health_status:")) == "unhealthy":
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *Ephemera) handleEvent(action, containerID string) {
+	e.Lock()
+	var found *Container
+	for _, c := range e.containers {
+		if c.ID == containerID {
+			found = c
+			break
+		}
+	}
+	if found != nil && isTerminal(action) {
+		delete(e.containers, found.Name)
+		if found.pool != nil {
+			found.pool.remove(found)
+		}
+	}
+	e.Unlock()
+
+	log.Printf("container event %v for %v", action, containerID)
+	e.emit(ContainerEvent{Action: action, Container: found, ID: containerID})
+}
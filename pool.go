@@ -0,0 +1,224 @@
+package ephemera
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// PoolConfig configures a Pool's sizing and eviction behavior.
+type PoolConfig struct {
+	// MinIdle is the number of pre-started containers the pool tries to
+	// keep ready at all times.
+	MinIdle int
+	// MaxTotal caps the number of containers (idle + in use) the pool
+	// will ever have alive at once.
+	MaxTotal int
+	// MaxInUse caps the number of containers handed out and not yet
+	// killed. Get returns an error once this limit is reached.
+	MaxInUse int
+	// IdleEvictInterval is how often the pool checks idle containers for
+	// eviction. A container idle for longer than IdleTTL is killed and,
+	// if still under MinIdle, replaced.
+	IdleEvictInterval time.Duration
+	// IdleTTL is the maximum time a pre-warmed container may sit idle
+	// before being evicted and replaced with a fresh one.
+	IdleTTL time.Duration
+}
+
+// Pool keeps a set of pre-created, pre-started containers of a single
+// image ready to hand out, so /demo/new doesn't pay create+start+readiness
+// latency on every request.
+type Pool struct {
+	e     *Ephemera
+	image string
+	ttl   time.Duration
+	cfg   PoolConfig
+
+	mu    sync.Mutex
+	idle  []*Container
+	inUse map[string]*Container
+	total int
+	// pending counts Gets that passed the MaxInUse check but haven't
+	// added their container to inUse yet.
+	pending int
+
+	stop chan struct{}
+}
+
+// NewPool creates a Pool that hands out containers of image with the
+// given TTL. Call Start to begin pre-warming.
+func (e *Ephemera) NewPool(image string, ttl time.Duration, cfg PoolConfig) *Pool {
+	p := &Pool{
+		e:     e,
+		image: image,
+		ttl:   ttl,
+		cfg:   cfg,
+		inUse: map[string]*Container{},
+		stop:  make(chan struct{}),
+	}
+	e.pool = p
+	return p
+}
+
+// Start pre-warms the pool up to MinIdle and begins the idle-eviction loop.
+// It returns once the initial fill is done (or ctx is cancelled).
+func (p *Pool) Start(ctx context.Context) {
+	p.fill(ctx)
+	go p.evictLoop()
+}
+
+// Stop ends the idle-eviction loop. Containers already handed out or
+// sitting idle are left untouched; kill them with Ephemera.KillAll.
+func (p *Pool) Stop() {
+	close(p.stop)
+}
+
+func (p *Pool) evictLoop() {
+	interval := p.cfg.IdleEvictInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.evictIdle()
+		}
+	}
+}
+
+func (p *Pool) evictIdle() {
+	p.mu.Lock()
+	var fresh []*Container
+	var stale []*Container
+	for _, c := range p.idle {
+		if p.cfg.IdleTTL > 0 && time.Since(c.StartedAt) > p.cfg.IdleTTL {
+			stale = append(stale, c)
+			p.total--
+			continue
+		}
+		fresh = append(fresh, c)
+	}
+	p.idle = fresh
+	p.mu.Unlock()
+
+	for _, c := range stale {
+		log.Printf("evicting idle container %v", c)
+		if err := c.Kill(context.Background()); err != nil {
+			log.Printf("failed to evict %v: %v", c, err)
+		}
+	}
+	p.fill(context.Background())
+}
+
+// fill tops the idle set back up to MinIdle, starting as many containers
+// as needed without exceeding MaxTotal.
+func (p *Pool) fill(ctx context.Context) {
+	for {
+		p.mu.Lock()
+		need := p.cfg.MinIdle-len(p.idle) > 0 && (p.cfg.MaxTotal <= 0 || p.total < p.cfg.MaxTotal)
+		if !need {
+			p.mu.Unlock()
+			return
+		}
+		p.total++
+		p.mu.Unlock()
+
+		c := p.e.NewContainer(p.image, p.ttl)
+		c.pool = p
+		if err := c.Start(ctx); err != nil {
+			log.Printf("pool: failed to pre-warm container for %v: %v", p.image, err)
+			if c.ID != "" {
+				if kerr := c.Kill(context.Background()); kerr != nil {
+					log.Printf("failed to clean up %v: %v", c, kerr)
+				}
+			}
+			p.mu.Lock()
+			p.total--
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Lock()
+		p.idle = append(p.idle, c)
+		p.mu.Unlock()
+	}
+}
+
+// Get hands out an idle container, falling back to creating one on demand
+// if the idle set is empty. It asynchronously starts a replacement so the
+// idle set refills in the background. It returns an error once MaxInUse
+// in-use containers are already outstanding.
+func (p *Pool) Get(ctx context.Context) (*Container, error) {
+	p.mu.Lock()
+	if p.cfg.MaxInUse > 0 && len(p.inUse)+p.pending >= p.cfg.MaxInUse {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("pool exhausted: %v containers already in use", len(p.inUse)+p.pending)
+	}
+	p.pending++
+	var c *Container
+	if len(p.idle) > 0 {
+		c = p.idle[0]
+		p.idle = p.idle[1:]
+	}
+	p.mu.Unlock()
+
+	if c == nil {
+		p.mu.Lock()
+		if p.cfg.MaxTotal > 0 && p.total >= p.cfg.MaxTotal {
+			p.pending--
+			p.mu.Unlock()
+			return nil, fmt.Errorf("pool exhausted: %v containers already alive", p.total)
+		}
+		p.total++
+		p.mu.Unlock()
+		c = p.e.NewContainer(p.image, p.ttl)
+		c.pool = p
+		if err := c.Start(ctx); err != nil {
+			if c.ID != "" {
+				if kerr := c.Kill(context.Background()); kerr != nil {
+					log.Printf("failed to clean up %v: %v", c, kerr)
+				}
+			}
+			p.mu.Lock()
+			p.total--
+			p.pending--
+			p.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	p.pending--
+	p.inUse[c.Name] = c
+	p.mu.Unlock()
+
+	go p.fill(context.Background())
+	return c, nil
+}
+
+// remove drops c from the pool's bookkeeping, called when c is killed. c
+// may be in use, sitting idle, or already gone from both (e.g. evictIdle
+// already spliced it out of idle before killing it), in which case remove
+// is a no-op.
+func (p *Pool) remove(c *Container) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.inUse[c.Name]; ok {
+		delete(p.inUse, c.Name)
+		p.total--
+		return
+	}
+	for i, ic := range p.idle {
+		if ic == c {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			p.total--
+			return
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package ephemera
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// fakeDocker implements client.APIClient well enough to drive
+// Container.Start/Kill without a real daemon: it hands out unique
+// container IDs and reports 127.0.0.1 as the container's IP, so a probe
+// pointed at a real local listener can observe it as ready.
+type fakeDocker struct {
+	client.APIClient
+
+	mu      sync.Mutex
+	nextID  int
+	created int
+}
+
+func (f *fakeDocker) ContainerCreate(ctx context.Context, cfg *container.Config, hostCfg *container.HostConfig, netCfg *network.NetworkingConfig, platform *ocispec.Platform, name string) (container.CreateResponse, error) {
+	f.mu.Lock()
+	f.nextID++
+	f.created++
+	id := "fake" + string(rune('a'+f.nextID))
+	f.mu.Unlock()
+	return container.CreateResponse{ID: id}, nil
+}
+
+func (f *fakeDocker) ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error {
+	return nil
+}
+
+func (f *fakeDocker) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: containerID},
+		NetworkSettings: &types.NetworkSettings{
+			DefaultNetworkSettings: types.DefaultNetworkSettings{IPAddress: "127.0.0.1"},
+		},
+	}, nil
+}
+
+func (f *fakeDocker) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	return nil
+}
+
+func (f *fakeDocker) ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error {
+	return nil
+}
+
+// newTestEphemera returns an Ephemera backed by fakeDocker, with a TCP
+// probe pointed at a local listener so Container.Start's readiness check
+// succeeds immediately without touching a real Docker daemon.
+func newTestEphemera(t *testing.T) *Ephemera {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to open test listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	e := &Ephemera{
+		containers: map[string]*Container{},
+		docker:     &fakeDocker{},
+		ttl:        time.Hour,
+		image:      "test-image",
+		probes:     map[string]*Probe{},
+	}
+	e.SetProbe("test-image", NewProbe(port, 2*time.Second))
+	return e
+}
+
+func TestPoolGetEnforcesMaxTotal(t *testing.T) {
+	e := newTestEphemera(t)
+	p := e.NewPool("test-image", time.Hour, PoolConfig{MaxTotal: 2})
+
+	var wg sync.WaitGroup
+	var ok, failed int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.Get(context.Background()); err != nil {
+				atomic.AddInt32(&failed, 1)
+				return
+			}
+			atomic.AddInt32(&ok, 1)
+		}()
+	}
+	wg.Wait()
+
+	if ok != 2 {
+		t.Fatalf("expected exactly 2 successful Gets under MaxTotal=2, got %v (failed=%v)", ok, failed)
+	}
+	p.mu.Lock()
+	total := p.total
+	p.mu.Unlock()
+	if total != 2 {
+		t.Fatalf("expected p.total == 2, got %v", total)
+	}
+}
+
+func TestPoolGetAndFillRespectMaxTotalConcurrently(t *testing.T) {
+	e := newTestEphemera(t)
+	p := e.NewPool("test-image", time.Hour, PoolConfig{MinIdle: 5, MaxTotal: 3})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.fill(context.Background())
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Get(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	total := p.total
+	p.mu.Unlock()
+	if total > 3 {
+		t.Fatalf("expected p.total capped at MaxTotal=3, got %v", total)
+	}
+}
+
+func TestPoolRemoveStripsIdleContainer(t *testing.T) {
+	e := newTestEphemera(t)
+	p := e.NewPool("test-image", time.Hour, PoolConfig{MinIdle: 1, MaxTotal: 5})
+	p.fill(context.Background())
+
+	p.mu.Lock()
+	if len(p.idle) != 1 {
+		p.mu.Unlock()
+		t.Fatalf("expected 1 idle container after fill, got %v", len(p.idle))
+	}
+	c := p.idle[0]
+	startTotal := p.total
+	p.mu.Unlock()
+
+	// Simulate the container dying while still idle, as handleEvent does.
+	p.remove(c)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) != 0 {
+		t.Fatalf("expected idle container to be spliced out, still have %v", len(p.idle))
+	}
+	if p.total != startTotal-1 {
+		t.Fatalf("expected p.total to drop by 1, got %v (was %v)", p.total, startTotal)
+	}
+}
@@ -0,0 +1,118 @@
+package ephemera
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ProbeKind selects the strategy used to decide whether a container's
+// upstream is ready to accept traffic.
+type ProbeKind int
+
+const (
+	// ProbeTCP succeeds as soon as a TCP connection to Port can be
+	// established. This is the default and works for most applications.
+	ProbeTCP ProbeKind = iota
+	// ProbeHTTP issues a GET request to Path and succeeds once the
+	// response status matches ExpectedStatus.
+	ProbeHTTP
+)
+
+// Probe describes how to wait for a container's application to become
+// ready before it is handed to a client. The zero value is not usable;
+// build one with NewProbe or NewHTTPProbe.
+type Probe struct {
+	Kind           ProbeKind
+	Port           int
+	Path           string
+	ExpectedStatus int
+	Timeout        time.Duration
+	Interval       time.Duration
+}
+
+// DefaultProbe is used for images that don't have a probe registered
+// explicitly via Ephemera.SetProbe.
+var DefaultProbe = &Probe{
+	Kind:     ProbeTCP,
+	Port:     8080,
+	Timeout:  10 * time.Second,
+	Interval: 100 * time.Millisecond,
+}
+
+// NewProbe builds a TCP readiness probe for the given port.
+func NewProbe(port int, timeout time.Duration) *Probe {
+	return &Probe{
+		Kind:     ProbeTCP,
+		Port:     port,
+		Timeout:  timeout,
+		Interval: 100 * time.Millisecond,
+	}
+}
+
+// NewHTTPProbe builds an HTTP readiness probe that expects expectedStatus
+// from a GET request to path on the given port.
+func NewHTTPProbe(port int, path string, expectedStatus int, timeout time.Duration) *Probe {
+	return &Probe{
+		Kind:           ProbeHTTP,
+		Port:           port,
+		Path:           path,
+		ExpectedStatus: expectedStatus,
+		Timeout:        timeout,
+		Interval:       100 * time.Millisecond,
+	}
+}
+
+// ErrProbeTimeout is returned by Container.Start when a container's
+// readiness probe didn't succeed before its Timeout elapsed.
+type ErrProbeTimeout struct {
+	Addr string
+}
+
+func (e *ErrProbeTimeout) Error() string {
+	return fmt.Sprintf("probe for %v timed out", e.Addr)
+}
+
+// wait blocks until the probe succeeds against ip, ctx is cancelled or the
+// probe's Timeout elapses, whichever comes first.
+func (p *Probe) wait(ctx context.Context, ip string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	addr := fmt.Sprintf("%v:%v", ip, p.Port)
+	for {
+		if p.ready(ctx, addr) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return &ErrProbeTimeout{Addr: addr}
+		case <-time.After(p.Interval):
+		}
+	}
+}
+
+func (p *Probe) ready(ctx context.Context, addr string) bool {
+	switch p.Kind {
+	case ProbeHTTP:
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%v%v", addr, p.Path), nil)
+		if err != nil {
+			return false
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode == p.ExpectedStatus
+	default:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+}
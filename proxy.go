@@ -0,0 +1,93 @@
+package ephemera
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// RouteMode selects how a container's proxy is reached by clients.
+type RouteMode int
+
+const (
+	// PathPrefix routes requests under /demo/{id}/, stripping the prefix
+	// before forwarding. This is the default and needs no DNS setup, but
+	// breaks apps that emit absolute URLs.
+	PathPrefix RouteMode = iota
+	// Subdomain routes requests to {id}.BaseDomain, forwarding the
+	// request untouched so the app sees a clean root path. Requires
+	// SetBaseDomain and a wildcard DNS entry pointing at ephemera.
+	Subdomain
+)
+
+// SetBaseDomain sets the domain used to build per-container hostnames in
+// Subdomain routing mode, e.g. "demo.example.com" for containers reachable
+// at "{id}.demo.example.com".
+func (e *Ephemera) SetBaseDomain(domain string) {
+	e.Lock()
+	defer e.Unlock()
+	e.baseDomain = domain
+}
+
+// newProxy builds a reverse proxy to target. Unlike a plain
+// httputil.NewSingleHostReverseProxy, it upgrades WebSocket connections by
+// hijacking the client connection and piping it directly to the backend,
+// since ReverseProxy doesn't support the Upgrade handshake on its own.
+func newProxy(target *url.URL, stripPrefix string) http.Handler {
+	rp := httputil.NewSingleHostReverseProxy(target)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWebsocketUpgrade(r) {
+			proxyWebsocket(w, r, target)
+			return
+		}
+		rp.ServeHTTP(w, r)
+	})
+	if stripPrefix == "" {
+		return h
+	}
+	return http.StripPrefix(stripPrefix, h)
+}
+
+func isWebsocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// proxyWebsocket dials target, hijacks the client connection and pipes
+// bytes between the two until either side closes.
+func proxyWebsocket(w http.ResponseWriter, r *http.Request, target *url.URL) {
+	backendConn, err := net.Dial("tcp", target.Host)
+	if err != nil {
+		http.Error(w, "failed to reach upstream", http.StatusBadGateway)
+		return
+	}
+	defer backendConn.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, "failed to hijack connection", http.StatusInternalServerError)
+		return
+	}
+	defer clientConn.Close()
+
+	if err := r.Write(backendConn); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst io.Writer, src io.Reader) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go pipe(backendConn, clientConn)
+	go pipe(clientConn, backendConn)
+	<-done
+}
@@ -0,0 +1,87 @@
+package ephemera
+
+import (
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+// ContainerSpec holds the Docker configuration applied to a container
+// beyond its image, built up via ContainerOption and passed to
+// NewContainer. The zero value runs the image with no resource limits on
+// the default bridge network, which is fine for trusted images but not
+// for untrusted demo code.
+type ContainerSpec struct {
+	Env            []string
+	Labels         map[string]string
+	Memory         int64 // bytes, 0 means unlimited
+	NanoCPUs       int64 // 1e9 NanoCPUs == 1 CPU, 0 means unlimited
+	NetworkMode    container.NetworkMode
+	PortBindings   nat.PortMap
+	Binds          []string
+	ReadonlyRootfs bool
+	StopSignal     string
+	StopTimeout    *int
+}
+
+// ContainerOption configures a ContainerSpec. Options are applied in
+// order, so a later option overrides an earlier one touching the same
+// field.
+type ContainerOption func(*ContainerSpec)
+
+// WithEnv sets the container's environment variables, each in "KEY=value"
+// form.
+func WithEnv(env ...string) ContainerOption {
+	return func(s *ContainerSpec) { s.Env = env }
+}
+
+// WithLabels sets the container's labels.
+func WithLabels(labels map[string]string) ContainerOption {
+	return func(s *ContainerSpec) { s.Labels = labels }
+}
+
+// WithMemory caps the container's memory usage, in bytes.
+func WithMemory(bytes int64) ContainerOption {
+	return func(s *ContainerSpec) { s.Memory = bytes }
+}
+
+// WithNanoCPUs caps the container's CPU usage, in units of 1e-9 CPUs
+// (1e9 == 1 full CPU).
+func WithNanoCPUs(n int64) ContainerOption {
+	return func(s *ContainerSpec) { s.NanoCPUs = n }
+}
+
+// WithNetworkMode puts the container on the given network instead of the
+// default bridge, e.g. "none" to disable networking or the name of a
+// user-defined bridge network.
+func WithNetworkMode(mode string) ContainerOption {
+	return func(s *ContainerSpec) { s.NetworkMode = container.NetworkMode(mode) }
+}
+
+// WithPortBindings publishes the container's ports on the host.
+func WithPortBindings(bindings nat.PortMap) ContainerOption {
+	return func(s *ContainerSpec) { s.PortBindings = bindings }
+}
+
+// WithBinds mounts host paths into the container, each in
+// "/host/path:/container/path[:ro]" form.
+func WithBinds(binds ...string) ContainerOption {
+	return func(s *ContainerSpec) { s.Binds = binds }
+}
+
+// WithReadonlyRootfs mounts the container's root filesystem read-only,
+// recommended for untrusted images.
+func WithReadonlyRootfs() ContainerOption {
+	return func(s *ContainerSpec) { s.ReadonlyRootfs = true }
+}
+
+// WithStopSignal overrides the signal sent to stop the container
+// (defaults to SIGTERM).
+func WithStopSignal(signal string) ContainerOption {
+	return func(s *ContainerSpec) { s.StopSignal = signal }
+}
+
+// WithStopTimeout overrides how long, in seconds, Kill waits after
+// sending the stop signal before killing the container.
+func WithStopTimeout(seconds int) ContainerOption {
+	return func(s *ContainerSpec) { s.StopTimeout = &seconds }
+}
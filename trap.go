@@ -0,0 +1,70 @@
+package ephemera
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Trap installs a SIGINT/SIGTERM handler that kills every spawned
+// container before the process exits, mirroring the Docker engine's own
+// signal handling: the first signal starts a graceful KillAll, further
+// repeats of the signal are ignored while it runs, and a third signal
+// forces an immediate exit without waiting for cleanup to finish.
+func (e *Ephemera) Trap() {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		var interrupts uint32
+		for range sigc {
+			switch atomic.AddUint32(&interrupts, 1) {
+			case 1:
+				go func() {
+					log.Println("received shutdown signal, killing all containers")
+					e.KillAll(context.Background())
+					os.Exit(0)
+				}()
+			case 3:
+				log.Println("received 3 shutdown signals, forcing exit")
+				os.Exit(128)
+			}
+		}
+	}()
+}
+
+// Reconcile lists containers left over from a previous, possibly crashed,
+// ephemera process (recognized by their "ephemera-" name prefix) and kills
+// them. ephemera doesn't persist container state across restarts, so a
+// leftover container can't safely be adopted back into e.containers -- its
+// TTL and proxy are gone -- and the only safe move is to tear it down.
+// Call Reconcile once at startup, before serving requests, so restarts
+// don't leak resources.
+func (e *Ephemera) Reconcile(ctx context.Context) error {
+	f := filters.NewArgs()
+	// Docker matches the name filter as an unanchored regexp against names
+	// that may carry a leading "/", so anchor it to keep this a prefix match.
+	f.Add("name", "^/?"+containerPrefix+"-")
+	orphans, err := e.docker.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: f})
+	if err != nil {
+		return fmt.Errorf("failed to list existing containers: %v", err)
+	}
+	for _, info := range orphans {
+		log.Printf("found orphaned container %v (%v) from a previous run, killing it", info.ID, info.Names)
+		timeout := 5
+		if err := e.docker.ContainerStop(ctx, info.ID, container.StopOptions{Timeout: &timeout}); err != nil {
+			log.Printf("failed to stop orphaned container %v: %v", info.ID, err)
+		}
+		if err := e.docker.ContainerRemove(ctx, info.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			log.Printf("failed to remove orphaned container %v: %v", info.ID, err)
+		}
+	}
+	return nil
+}